@@ -0,0 +1,276 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	sess "github.com/pingcap/tidb/pkg/ddl/session"
+	"github.com/pingcap/tidb/pkg/util/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	backoffInitialInterval = 500 * time.Millisecond
+	backoffMultiplier      = 1.5
+	backoffMaxInterval     = 5 * time.Minute
+	// backoffJitterFraction is the +/-20% jitter applied to every computed
+	// interval so that handlers backing off at the same time do not all
+	// retry in lockstep.
+	backoffJitterFraction = 0.2
+
+	// maxDeadLetterAttempts is the number of consecutive failed attempts a
+	// (schemaChange, handler) pair tolerates before it is moved to the
+	// dead-letter store.
+	maxDeadLetterAttempts = 20
+	// maxDeadLetterElapsed bounds how long a (schemaChange, handler) pair may
+	// keep failing before it is moved to the dead-letter store, regardless of
+	// attempt count.
+	maxDeadLetterElapsed = 24 * time.Hour
+)
+
+// backoffState is the persisted state of one HandlerID's backoff schedule.
+// Persisting it means a restart of the ddlNotifier does not reset the
+// schedule and immediately hammer a handler that was already backing off.
+type backoffState struct {
+	attempt      int
+	nextEligible time.Time
+}
+
+func (s backoffState) ready(now time.Time) bool {
+	return !now.Before(s.nextEligible)
+}
+
+// next returns the state after one more failure, applying the exponential
+// schedule and +/-20% jitter.
+func (s backoffState) next(now time.Time) backoffState {
+	interval := time.Duration(float64(backoffInitialInterval) * math.Pow(backoffMultiplier, float64(s.attempt)))
+	if interval > backoffMaxInterval {
+		interval = backoffMaxInterval
+	}
+	jitter := 1 + backoffJitterFraction*(2*rand.Float64()-1)
+	interval = time.Duration(float64(interval) * jitter)
+	return backoffState{
+		attempt:      s.attempt + 1,
+		nextEligible: now.Add(interval),
+	}
+}
+
+// backoffController gates how often one partition of a handler's worker pool
+// is allowed to attempt new work, and persists its state through the Store so
+// a process restart resumes the same schedule instead of resetting it.
+type backoffController struct {
+	id       HandlerID
+	notifier *ddlNotifier
+
+	mu    sync.Mutex
+	state backoffState
+}
+
+func newBackoffController(n *ddlNotifier, id HandlerID, initial backoffState) *backoffController {
+	return &backoffController{id: id, notifier: n, state: initial}
+}
+
+// partitionBackoffID derives the HandlerID used to key one partition's
+// persisted backoff state, reusing the Store's existing
+// SaveHandlerBackoff/LoadHandlerBackoff API (keyed by HandlerID) without
+// widening it to accept a separate partition number.
+func partitionBackoffID(id HandlerID, partition int) HandlerID {
+	return HandlerID(fmt.Sprintf("%s#%d", id, partition))
+}
+
+// ready reports whether the controller's partition may be dispatched to now.
+func (c *backoffController) ready(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.ready(now)
+}
+
+// onFailure advances the backoff schedule after a handler error and persists
+// the new state.
+func (c *backoffController) onFailure(ctx context.Context, now time.Time) {
+	c.mu.Lock()
+	c.state = c.state.next(now)
+	state := c.state
+	c.mu.Unlock()
+	c.persist(ctx, state)
+}
+
+// onSuccess resets the backoff schedule after a handler succeeds.
+func (c *backoffController) onSuccess(ctx context.Context) {
+	c.mu.Lock()
+	wasBackingOff := c.state.attempt > 0
+	c.state = backoffState{}
+	c.mu.Unlock()
+	if wasBackingOff {
+		c.persist(ctx, backoffState{})
+	}
+}
+
+func (c *backoffController) persist(ctx context.Context, state backoffState) {
+	if err := c.notifier.store.SaveHandlerBackoff(
+		ctx,
+		sess.NewSession(c.notifier.ownedSCtx),
+		c.id,
+		state.attempt,
+		state.nextEligible,
+	); err != nil {
+		logutil.Logger(ctx).Warn("Failed to persist ddl notifier backoff state",
+			zap.Stringer("handler", c.id), zap.Error(err))
+	}
+}
+
+func (n *ddlNotifier) loadBackoffState(ctx context.Context, id HandlerID) backoffState {
+	attempt, nextEligible, err := n.store.LoadHandlerBackoff(ctx, sess.NewSession(n.ownedSCtx), id)
+	if err != nil {
+		logutil.Logger(ctx).Warn("Failed to load persisted ddl notifier backoff state, starting fresh",
+			zap.Stringer("handler", id), zap.Error(err))
+		return backoffState{}
+	}
+	return backoffState{attempt: attempt, nextEligible: nextEligible}
+}
+
+// deadLetterKey identifies one (schemaChange, handler) pair for the purpose
+// of counting repeated failures.
+type deadLetterKey struct {
+	ddlJobID             int64
+	multiSchemaChangeSeq int64
+	handlerID            HandlerID
+}
+
+type deadLetterEntry struct {
+	attempts      int
+	firstFailedAt time.Time
+}
+
+// deadLetterTracker counts consecutive failures per (schemaChange, handler)
+// pair in memory, independently of the per-handler backoffController, so a
+// single stuck change can be promoted to the dead-letter store without
+// penalizing the handler's other, healthy changes.
+type deadLetterTracker struct {
+	mu    sync.Mutex
+	state map[deadLetterKey]*deadLetterEntry
+}
+
+func newDeadLetterTracker() *deadLetterTracker {
+	return &deadLetterTracker{state: make(map[deadLetterKey]*deadLetterEntry)}
+}
+
+func (t *deadLetterTracker) recordFailure(key deadLetterKey, now time.Time) (attempts int, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.state[key]
+	if !ok {
+		e = &deadLetterEntry{firstFailedAt: now}
+		t.state[key] = e
+	}
+	e.attempts++
+	return e.attempts, now.Sub(e.firstFailedAt)
+}
+
+func (t *deadLetterTracker) clear(key deadLetterKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.state, key)
+}
+
+// recordFailureAndMaybeDeadLetter updates the dead-letter attempt count for
+// (change, handlerID) and, once it crosses n.deadLetterMaxAttempts or
+// n.deadLetterMaxElapsed, promotes the pair to the dead-letter store so it
+// stops blocking DeleteAndCommit for the handlers that have already processed
+// it.
+func (n *ddlNotifier) recordFailureAndMaybeDeadLetter(
+	ctx context.Context,
+	change *schemaChange,
+	handlerID HandlerID,
+	cause error,
+) {
+	key := deadLetterKey{change.ddlJobID, change.multiSchemaChangeSeq, handlerID}
+	attempts, elapsed := n.deadLetters.recordFailure(key, time.Now())
+	if attempts < n.deadLetterMaxAttempts && elapsed < n.deadLetterMaxElapsed {
+		return
+	}
+
+	if err := n.store.MarkDeadLetter(
+		ctx,
+		sess.NewSession(n.ownedSCtx),
+		change.ddlJobID,
+		change.multiSchemaChangeSeq,
+		handlerID,
+		cause.Error(),
+	); err != nil {
+		logutil.Logger(ctx).Error("Error moving change to dead letter store",
+			zap.Int64("ddlJobID", change.ddlJobID),
+			zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
+			zap.Stringer("handler", handlerID),
+			zap.Error(err))
+		return
+	}
+	n.deadLetters.clear(key)
+	n.mu.Lock()
+	if change.deadLettered == nil {
+		change.deadLettered = make(map[HandlerID]struct{})
+	}
+	change.deadLettered[handlerID] = struct{}{}
+	n.mu.Unlock()
+	logutil.Logger(ctx).Warn("Moved change to dead letter store after repeated failures",
+		zap.Int64("ddlJobID", change.ddlJobID),
+		zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
+		zap.Stringer("handler", handlerID),
+		zap.Int("attempts", attempts),
+		zap.Duration("elapsed", elapsed))
+}
+
+func (n *ddlNotifier) clearDeadLetterTracking(change *schemaChange, handlerID HandlerID) {
+	n.deadLetters.clear(deadLetterKey{change.ddlJobID, change.multiSchemaChangeSeq, handlerID})
+}
+
+// DeadLetter describes a (schemaChange, handler) pair that failed repeatedly
+// and was removed from the normal delivery path.
+type DeadLetter struct {
+	DDLJobID             int64
+	MultiSchemaChangeSeq int64
+	HandlerID            HandlerID
+	LastError            string
+}
+
+// ListDeadLetters returns every change currently held in the dead-letter
+// store, across all handlers.
+func ListDeadLetters(ctx context.Context) ([]*DeadLetter, error) {
+	letters, err := globalDDLNotifier.store.ListDeadLetters(ctx, sess.NewSession(globalDDLNotifier.ownedSCtx))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return letters, nil
+}
+
+// RetryDeadLetter removes the dead-letter entry for (ddlJobID,
+// multiSchemaChangeSeq, handlerID) so the ddlNotifier attempts to deliver it
+// to that handler again on the next poll tick.
+func RetryDeadLetter(ctx context.Context, ddlJobID int64, multiSchemaChangeSeq int64, handlerID HandlerID) error {
+	return errors.Trace(globalDDLNotifier.store.RetryDeadLetter(
+		ctx,
+		sess.NewSession(globalDDLNotifier.ownedSCtx),
+		ddlJobID,
+		multiSchemaChangeSeq,
+		handlerID,
+	))
+}
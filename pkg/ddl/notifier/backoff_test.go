@@ -0,0 +1,110 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	goerr "errors"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/ddl/session"
+	"github.com/stretchr/testify/require"
+)
+
+var errBoom = goerr.New("boom")
+
+// fakeStore is a minimal in-memory Store used only to exercise
+// recordFailureAndMaybeDeadLetter without a real SQL backend.
+type fakeStore struct{}
+
+func (*fakeStore) List(context.Context, *session.Session) ([]*schemaChange, error)      { return nil, nil }
+func (*fakeStore) Ack(context.Context, *session.Session, int64, int64, HandlerID) error { return nil }
+func (*fakeStore) DeleteAndCommit(context.Context, *session.Session, int64, int) error  { return nil }
+func (*fakeStore) SaveHandlerBackoff(context.Context, *session.Session, HandlerID, int, time.Time) error {
+	return nil
+}
+func (*fakeStore) LoadHandlerBackoff(context.Context, *session.Session, HandlerID) (int, time.Time, error) {
+	return 0, time.Time{}, nil
+}
+func (*fakeStore) MarkDeadLetter(context.Context, *session.Session, int64, int64, HandlerID, string) error {
+	return nil
+}
+func (*fakeStore) ListDeadLetters(context.Context, *session.Session) ([]*DeadLetter, error) {
+	return nil, nil
+}
+func (*fakeStore) RetryDeadLetter(context.Context, *session.Session, int64, int64, HandlerID) error {
+	return nil
+}
+
+func TestBackoffStateNextIsExponentialWithinJitter(t *testing.T) {
+	now := time.Now()
+	s := backoffState{}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		next := s.next(now)
+		require.Equal(t, attempt+1, next.attempt)
+
+		want := float64(backoffInitialInterval) * pow(backoffMultiplier, float64(attempt))
+		if want > float64(backoffMaxInterval) {
+			want = float64(backoffMaxInterval)
+		}
+		delta := next.nextEligible.Sub(now)
+		lower := time.Duration(want * (1 - backoffJitterFraction))
+		upper := time.Duration(want * (1 + backoffJitterFraction))
+		require.GreaterOrEqualf(t, delta, lower, "attempt %d", attempt)
+		require.LessOrEqualf(t, delta, upper, "attempt %d", attempt)
+
+		s = next
+	}
+}
+
+func TestBackoffStateReady(t *testing.T) {
+	now := time.Now()
+	s := backoffState{nextEligible: now.Add(time.Minute)}
+
+	require.False(t, s.ready(now))
+	require.True(t, s.ready(now.Add(2*time.Minute)))
+}
+
+func TestRecordFailureAndMaybeDeadLetterUnblocksIsProcessed(t *testing.T) {
+	n := &ddlNotifier{
+		store:                 &fakeStore{},
+		deadLetters:           newDeadLetterTracker(),
+		deadLetterMaxAttempts: maxDeadLetterAttempts,
+		deadLetterMaxElapsed:  maxDeadLetterElapsed,
+	}
+	change := &schemaChange{ddlJobID: 1, multiSchemaChangeSeq: 1}
+	ctx := context.Background()
+
+	for i := 0; i < maxDeadLetterAttempts-1; i++ {
+		n.recordFailureAndMaybeDeadLetter(ctx, change, "h1", errBoom)
+		require.False(t, n.isProcessed(change, "h1"))
+	}
+
+	n.recordFailureAndMaybeDeadLetter(ctx, change, "h1", errBoom)
+	// Once the attempt threshold is crossed, the pair is dead-lettered and
+	// must be treated as resolved so it stops blocking DeleteAndCommit for
+	// other handlers.
+	require.True(t, n.isProcessed(change, "h1"))
+}
+
+func pow(base, exp float64) float64 {
+	result := 1.0
+	for i := 0; i < int(exp); i++ {
+		result *= base
+	}
+	return result
+}
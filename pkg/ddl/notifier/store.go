@@ -0,0 +1,494 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+	sess "github.com/pingcap/tidb/pkg/ddl/session"
+)
+
+const (
+	// ddlNotifierTable holds one row per undelivered SchemaChangeEvent.
+	ddlNotifierTable = "mysql.tidb_ddl_notifier"
+	// ddlNotifierAckTable holds one row per (change, handler) pair that has
+	// been acknowledged. Its presence is what lets RegisterHandler support
+	// more handlers than a BIGINT UNSIGNED bitmap column ever could.
+	ddlNotifierAckTable = "mysql.tidb_ddl_notifier_ack"
+	// ddlNotifierDeadLetterTable holds one row per (change, handler) pair
+	// that failed repeatedly and was removed from the normal delivery path.
+	ddlNotifierDeadLetterTable = "mysql.tidb_ddl_notifier_dead_letter"
+	// ddlNotifierBackoffTable persists each handler's backoffController state
+	// so a restart resumes the schedule instead of resetting it.
+	ddlNotifierBackoffTable = "mysql.tidb_ddl_notifier_backoff"
+)
+
+// SchemaChangeEventType enumerates the kinds of DDL the publisher can emit a
+// SchemaChangeEvent for.
+type SchemaChangeEventType int
+
+const (
+	schemaChangeEventTypeUnknown SchemaChangeEventType = iota
+	// SchemaChangeEventTypeCreateTable is emitted after a CREATE TABLE job.
+	SchemaChangeEventTypeCreateTable
+	// SchemaChangeEventTypeDropTable is emitted after a DROP TABLE job.
+	SchemaChangeEventTypeDropTable
+	// SchemaChangeEventTypeAlterTable is emitted after any other table DDL
+	// job, including multi-schema-change jobs.
+	SchemaChangeEventTypeAlterTable
+)
+
+func (t SchemaChangeEventType) String() string {
+	switch t {
+	case SchemaChangeEventTypeCreateTable:
+		return "CreateTable"
+	case SchemaChangeEventTypeDropTable:
+		return "DropTable"
+	case SchemaChangeEventTypeAlterTable:
+		return "AlterTable"
+	default:
+		return "Unknown"
+	}
+}
+
+// SchemaChangeEvent contains the information of a schema change event that a
+// SchemaChangeHandler is invoked with. A zero value is not meaningful; it is
+// always constructed by the publisher from a finished DDL job.
+type SchemaChangeEvent struct {
+	tp      SchemaChangeEventType
+	schema  string
+	table   string
+	tableID int64
+}
+
+// String implements fmt.Stringer interface.
+func (e *SchemaChangeEvent) String() string {
+	if e == nil {
+		return "<nil>"
+	}
+	return e.tp.String()
+}
+
+// schemaChangeEventJSON is the on-disk representation of a SchemaChangeEvent,
+// stored as a JSON blob in ddlNotifierTable so new event fields do not need a
+// schema migration.
+type schemaChangeEventJSON struct {
+	Type    SchemaChangeEventType `json:"type"`
+	Schema  string                `json:"schema,omitempty"`
+	Table   string                `json:"table,omitempty"`
+	TableID int64                 `json:"table_id,omitempty"`
+}
+
+func (e *SchemaChangeEvent) marshal() ([]byte, error) {
+	return json.Marshal(schemaChangeEventJSON{
+		Type:    e.tp,
+		Schema:  e.schema,
+		Table:   e.table,
+		TableID: e.tableID,
+	})
+}
+
+func unmarshalSchemaChangeEvent(data []byte) (*SchemaChangeEvent, error) {
+	var raw schemaChangeEventJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &SchemaChangeEvent{tp: raw.Type, schema: raw.Schema, table: raw.Table, tableID: raw.TableID}, nil
+}
+
+// schemaChange is the in-memory representation of one row in ddlNotifierTable
+// together with the bookkeeping needed to decide whether it has been fully
+// delivered.
+type schemaChange struct {
+	ddlJobID             int64
+	multiSchemaChangeSeq int64
+	event                *SchemaChangeEvent
+
+	// processedByFlag is the legacy BIGINT UNSIGNED bitmap column. It is only
+	// ever read, never written, by code added after the upgrade to the ack
+	// table: it lets a HandlerID in legacyHandlerBits recognize a change as
+	// already processed without a matching row in ddlNotifierAckTable.
+	processedByFlag uint64
+
+	// ackedBy holds every HandlerID that has acknowledged this change via
+	// ddlNotifierAckTable, as populated by Store.List. processEventForHandler
+	// also adds to it directly after a successful Ack so the same poll tick
+	// can observe the update without a second round trip to the Store.
+	ackedBy map[HandlerID]struct{}
+
+	// deadLettered holds every HandlerID for which this change has been
+	// promoted to ddlNotifierDeadLetterTable. Those handlers are treated as
+	// resolved so a repeatedly failing handler does not block
+	// DeleteAndCommit for everyone else; see isProcessedLocked.
+	deadLettered map[HandlerID]struct{}
+}
+
+// Store persists schema change events and the bookkeeping the ddlNotifier
+// needs to deliver each of them to every registered handler exactly once.
+type Store interface {
+	// List returns every schemaChange that has not yet been deleted, together
+	// with the handlers that have already acknowledged or dead-lettered it,
+	// in the order they should be delivered.
+	List(ctx context.Context, se *sess.Session) ([]*schemaChange, error)
+
+	// Ack records that handlerID has processed (ddlJobID,
+	// multiSchemaChangeSeq) by upserting a row into ddlNotifierAckTable. It
+	// is idempotent: acking an already-acked pair is not an error.
+	Ack(
+		ctx context.Context,
+		se *sess.Session,
+		ddlJobID int64,
+		multiSchemaChangeSeq int64,
+		handlerID HandlerID,
+	) error
+
+	// DeleteAndCommit deletes the row for (ddlJobID, multiSchemaChangeSeq),
+	// along with any ack/dead-letter rows referencing it, and commits se's
+	// transaction.
+	DeleteAndCommit(
+		ctx context.Context,
+		se *sess.Session,
+		ddlJobID int64,
+		multiSchemaChangeSeq int,
+	) error
+
+	// SaveHandlerBackoff persists handlerID's backoffController state so a
+	// restart resumes the schedule instead of resetting it.
+	SaveHandlerBackoff(
+		ctx context.Context,
+		se *sess.Session,
+		handlerID HandlerID,
+		attempt int,
+		nextEligible time.Time,
+	) error
+
+	// LoadHandlerBackoff loads the state persisted by SaveHandlerBackoff. It
+	// returns the zero state if handlerID was never saved.
+	LoadHandlerBackoff(
+		ctx context.Context,
+		se *sess.Session,
+		handlerID HandlerID,
+	) (attempt int, nextEligible time.Time, err error)
+
+	// MarkDeadLetter moves (ddlJobID, multiSchemaChangeSeq, handlerID) into
+	// ddlNotifierDeadLetterTable, recording cause for inspection. After this
+	// call returns successfully, List reports handlerID as having
+	// dead-lettered the change, which tryDeleteChange treats the same as an
+	// acknowledgement.
+	MarkDeadLetter(
+		ctx context.Context,
+		se *sess.Session,
+		ddlJobID int64,
+		multiSchemaChangeSeq int64,
+		handlerID HandlerID,
+		cause string,
+	) error
+
+	// ListDeadLetters returns every dead-lettered (change, handler) pair,
+	// across all handlers.
+	ListDeadLetters(ctx context.Context, se *sess.Session) ([]*DeadLetter, error)
+
+	// RetryDeadLetter removes the dead-letter entry for (ddlJobID,
+	// multiSchemaChangeSeq, handlerID) so the ddlNotifier attempts to deliver
+	// it to that handler again on the next poll tick.
+	RetryDeadLetter(
+		ctx context.Context,
+		se *sess.Session,
+		ddlJobID int64,
+		multiSchemaChangeSeq int64,
+		handlerID HandlerID,
+	) error
+}
+
+// tableStore is the SQL-backed Store implementation used in production. It
+// still reads the legacy processedByFlag bitmap column on ddlNotifierTable,
+// for handlers in legacyHandlerBits, but never writes it: every
+// acknowledgement, including those handlers', is recorded in
+// ddlNotifierAckTable so RegisterHandler is no longer limited to 64 IDs.
+type tableStore struct{}
+
+// NewTableStore returns a Store backed by ddlNotifierTable and its
+// satellite tables, creating them if they do not already exist.
+func NewTableStore(ctx context.Context, se *sess.Session) (Store, error) {
+	s := &tableStore{}
+	if err := s.bootstrap(ctx, se); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s, nil
+}
+
+// bootstrap creates the tables tableStore relies on. It is safe to call
+// repeatedly: every statement is `CREATE TABLE IF NOT EXISTS`, so upgrading a
+// cluster that only has the legacy ddlNotifierTable bitmap column just adds
+// the new satellite tables alongside it without touching existing rows.
+func (s *tableStore) bootstrap(ctx context.Context, se *sess.Session) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + ddlNotifierTable + ` (
+			ddl_job_id BIGINT NOT NULL,
+			multi_schema_change_seq BIGINT NOT NULL DEFAULT -1,
+			event_json LONGBLOB NOT NULL,
+			processed_by_flag BIGINT UNSIGNED NOT NULL DEFAULT 0,
+			PRIMARY KEY (ddl_job_id, multi_schema_change_seq)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + ddlNotifierAckTable + ` (
+			ddl_job_id BIGINT NOT NULL,
+			multi_schema_change_seq BIGINT NOT NULL DEFAULT -1,
+			handler_id VARCHAR(255) NOT NULL,
+			PRIMARY KEY (ddl_job_id, multi_schema_change_seq, handler_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + ddlNotifierDeadLetterTable + ` (
+			ddl_job_id BIGINT NOT NULL,
+			multi_schema_change_seq BIGINT NOT NULL DEFAULT -1,
+			handler_id VARCHAR(255) NOT NULL,
+			cause TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (ddl_job_id, multi_schema_change_seq, handler_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + ddlNotifierBackoffTable + ` (
+			handler_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			attempt INT NOT NULL DEFAULT 0,
+			next_eligible TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := se.Execute(ctx, stmt, "ddl-notifier-bootstrap"); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (s *tableStore) List(ctx context.Context, se *sess.Session) ([]*schemaChange, error) {
+	rows, err := se.Execute(
+		ctx,
+		`SELECT ddl_job_id, multi_schema_change_seq, event_json, processed_by_flag FROM `+ddlNotifierTable+
+			` ORDER BY ddl_job_id, multi_schema_change_seq`,
+		"ddl-notifier-list",
+	)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	changes := make([]*schemaChange, 0, len(rows))
+	byKey := make(map[[2]int64]*schemaChange, len(rows))
+	for _, row := range rows {
+		event, err := unmarshalSchemaChangeEvent(row.GetBytes(2))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		change := &schemaChange{
+			ddlJobID:             row.GetInt64(0),
+			multiSchemaChangeSeq: row.GetInt64(1),
+			event:                event,
+			processedByFlag:      row.GetUint64(3),
+		}
+		changes = append(changes, change)
+		byKey[[2]int64{change.ddlJobID, change.multiSchemaChangeSeq}] = change
+	}
+
+	if err := s.fillAcked(ctx, se, byKey); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := s.fillDeadLettered(ctx, se, byKey); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return changes, nil
+}
+
+func (*tableStore) fillAcked(ctx context.Context, se *sess.Session, byKey map[[2]int64]*schemaChange) error {
+	rows, err := se.Execute(
+		ctx,
+		`SELECT ddl_job_id, multi_schema_change_seq, handler_id FROM `+ddlNotifierAckTable,
+		"ddl-notifier-list-acks",
+	)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, row := range rows {
+		change, ok := byKey[[2]int64{row.GetInt64(0), row.GetInt64(1)}]
+		if !ok {
+			continue
+		}
+		if change.ackedBy == nil {
+			change.ackedBy = make(map[HandlerID]struct{})
+		}
+		change.ackedBy[HandlerID(row.GetString(2))] = struct{}{}
+	}
+	return nil
+}
+
+func (*tableStore) fillDeadLettered(ctx context.Context, se *sess.Session, byKey map[[2]int64]*schemaChange) error {
+	rows, err := se.Execute(
+		ctx,
+		`SELECT ddl_job_id, multi_schema_change_seq, handler_id FROM `+ddlNotifierDeadLetterTable,
+		"ddl-notifier-list-dead-letters",
+	)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, row := range rows {
+		change, ok := byKey[[2]int64{row.GetInt64(0), row.GetInt64(1)}]
+		if !ok {
+			continue
+		}
+		if change.deadLettered == nil {
+			change.deadLettered = make(map[HandlerID]struct{})
+		}
+		change.deadLettered[HandlerID(row.GetString(2))] = struct{}{}
+	}
+	return nil
+}
+
+func (*tableStore) Ack(
+	ctx context.Context,
+	se *sess.Session,
+	ddlJobID int64,
+	multiSchemaChangeSeq int64,
+	handlerID HandlerID,
+) error {
+	_, err := se.ExecuteWithArgs(
+		ctx,
+		`INSERT IGNORE INTO `+ddlNotifierAckTable+` (ddl_job_id, multi_schema_change_seq, handler_id) VALUES (%?, %?, %?)`,
+		[]any{ddlJobID, multiSchemaChangeSeq, string(handlerID)},
+	)
+	return errors.Trace(err)
+}
+
+func (*tableStore) DeleteAndCommit(
+	ctx context.Context,
+	se *sess.Session,
+	ddlJobID int64,
+	multiSchemaChangeSeq int,
+) (err error) {
+	defer func() {
+		if err == nil {
+			err = errors.Trace(se.Commit(ctx))
+		} else {
+			se.Rollback()
+		}
+	}()
+
+	if err = se.Begin(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	for _, tbl := range []string{ddlNotifierAckTable, ddlNotifierDeadLetterTable} {
+		if _, err = se.ExecuteWithArgs(
+			ctx,
+			`DELETE FROM `+tbl+` WHERE ddl_job_id = %? AND multi_schema_change_seq = %?`,
+			[]any{ddlJobID, multiSchemaChangeSeq},
+		); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	_, err = se.ExecuteWithArgs(
+		ctx,
+		`DELETE FROM `+ddlNotifierTable+` WHERE ddl_job_id = %? AND multi_schema_change_seq = %?`,
+		[]any{ddlJobID, multiSchemaChangeSeq},
+	)
+	return errors.Trace(err)
+}
+
+func (*tableStore) SaveHandlerBackoff(
+	ctx context.Context,
+	se *sess.Session,
+	handlerID HandlerID,
+	attempt int,
+	nextEligible time.Time,
+) error {
+	_, err := se.ExecuteWithArgs(
+		ctx,
+		`INSERT INTO `+ddlNotifierBackoffTable+` (handler_id, attempt, next_eligible) VALUES (%?, %?, %?)
+			ON DUPLICATE KEY UPDATE attempt = VALUES(attempt), next_eligible = VALUES(next_eligible)`,
+		[]any{string(handlerID), attempt, nextEligible},
+	)
+	return errors.Trace(err)
+}
+
+func (*tableStore) LoadHandlerBackoff(
+	ctx context.Context,
+	se *sess.Session,
+	handlerID HandlerID,
+) (attempt int, nextEligible time.Time, err error) {
+	rows, err := se.ExecuteWithArgs(
+		ctx,
+		`SELECT attempt, next_eligible FROM `+ddlNotifierBackoffTable+` WHERE handler_id = %?`,
+		[]any{string(handlerID)},
+	)
+	if err != nil {
+		return 0, time.Time{}, errors.Trace(err)
+	}
+	if len(rows) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return int(rows[0].GetInt64(0)), rows[0].GetTime(1).CoreTime().GoTime(), nil
+}
+
+func (*tableStore) MarkDeadLetter(
+	ctx context.Context,
+	se *sess.Session,
+	ddlJobID int64,
+	multiSchemaChangeSeq int64,
+	handlerID HandlerID,
+	cause string,
+) error {
+	_, err := se.ExecuteWithArgs(
+		ctx,
+		`INSERT IGNORE INTO `+ddlNotifierDeadLetterTable+
+			` (ddl_job_id, multi_schema_change_seq, handler_id, cause) VALUES (%?, %?, %?, %?)`,
+		[]any{ddlJobID, multiSchemaChangeSeq, string(handlerID), cause},
+	)
+	return errors.Trace(err)
+}
+
+func (*tableStore) ListDeadLetters(ctx context.Context, se *sess.Session) ([]*DeadLetter, error) {
+	rows, err := se.Execute(
+		ctx,
+		`SELECT ddl_job_id, multi_schema_change_seq, handler_id, cause FROM `+ddlNotifierDeadLetterTable,
+		"ddl-notifier-list-dead-letters",
+	)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	letters := make([]*DeadLetter, 0, len(rows))
+	for _, row := range rows {
+		letters = append(letters, &DeadLetter{
+			DDLJobID:             row.GetInt64(0),
+			MultiSchemaChangeSeq: row.GetInt64(1),
+			HandlerID:            HandlerID(row.GetString(2)),
+			LastError:            row.GetString(3),
+		})
+	}
+	return letters, nil
+}
+
+func (*tableStore) RetryDeadLetter(
+	ctx context.Context,
+	se *sess.Session,
+	ddlJobID int64,
+	multiSchemaChangeSeq int64,
+	handlerID HandlerID,
+) error {
+	_, err := se.ExecuteWithArgs(
+		ctx,
+		`DELETE FROM `+ddlNotifierDeadLetterTable+
+			` WHERE ddl_job_id = %? AND multi_schema_change_seq = %? AND handler_id = %?`,
+		[]any{ddlJobID, multiSchemaChangeSeq, string(handlerID)},
+	)
+	return errors.Trace(err)
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	goerr "errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -25,6 +26,8 @@ import (
 	"github.com/pingcap/tidb/pkg/kv"
 	"github.com/pingcap/tidb/pkg/sessionctx"
 	"github.com/pingcap/tidb/pkg/util/logutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -53,41 +56,47 @@ type SchemaChangeHandler func(
 // ready to process the events.
 var ErrNotReadyRetryLater = errors.New("not ready, retry later")
 
-// HandlerID is the type of the persistent ID used to register a handler. Every
-// ID occupies a bit in a BIGINT column, so at most we can only have 64 IDs. To
-// avoid duplicate IDs, all IDs should be defined in below declaration.
-type HandlerID int
+// HandlerID is the persistent, caller-chosen identifier used to register a
+// handler. It used to be the bit position in a BIGINT UNSIGNED column, which
+// capped the module at 64 handlers; it is now an arbitrary non-empty string,
+// so hundreds of subscribers (e.g. per-tenant or per-plugin handlers) can
+// register. A HandlerID present in legacyHandlerBits is additionally checked
+// against the old BIGINT bitmap still stored in rows written before this
+// change, so an upgrade does not require redelivering already-processed
+// events.
+type HandlerID string
 
 const (
 	// TestHandlerID is used for testing only.
-	TestHandlerID HandlerID = 0
+	TestHandlerID HandlerID = "TestHandler"
 )
 
+// legacyHandlerBits maps the HandlerIDs that existed back when
+// processedByFlag was the only acknowledgement mechanism to their old bit
+// position. It must never gain new entries: it exists purely so rows written
+// before the upgrade to the ack-table scheme are still recognized as
+// processed without a matching row in the new ack table.
+var legacyHandlerBits = map[HandlerID]uint{
+	TestHandlerID: 0,
+}
+
 // String implements fmt.Stringer interface.
 func (id HandlerID) String() string {
-	switch id {
-	case TestHandlerID:
-		return "TestHandler"
-	default:
-		return fmt.Sprintf("HandlerID(%d)", id)
-	}
+	return string(id)
 }
 
-// RegisterHandler must be called with an exclusive and fixed HandlerID for each
-// handler to register the handler. Illegal ID will panic. RegisterHandler should
-// not be called after the global ddlNotifier is started.
+// RegisterHandler must be called with an exclusive, non-empty HandlerID for
+// each handler to register the handler. Illegal ID will panic. RegisterHandler
+// should not be called after the global ddlNotifier is started.
 //
 // RegisterHandler is not concurrency-safe.
 func RegisterHandler(id HandlerID, handler SchemaChangeHandler) {
-	intID := int(id)
-	// the ID is used by bit operation in processedByFlag. We use BIGINT UNSIGNED to
-	// store it so only 64 IDs are allowed.
-	if intID < 0 || intID >= 64 {
-		panic(fmt.Sprintf("illegal HandlerID: %d", id))
+	if id == "" {
+		panic("HandlerID must not be empty")
 	}
 
 	if _, ok := globalDDLNotifier.handlers[id]; ok {
-		panic(fmt.Sprintf("HandlerID %d already registered", id))
+		panic(fmt.Sprintf("HandlerID %q already registered", id))
 	}
 	globalDDLNotifier.handlers[id] = handler
 }
@@ -98,14 +107,79 @@ type ddlNotifier struct {
 	handlers     map[HandlerID]SchemaChangeHandler
 	pollInterval time.Duration
 
-	// handlersBitMap is set to the full bitmap of all registered handlers in Start.
-	handlersBitMap uint64
+	// handlerConcurrency is the number of workers started per handler unless
+	// overridden for that HandlerID through handlerConcurrencyOverrides.
+	handlerConcurrency          int
+	handlerConcurrencyOverrides map[HandlerID]int
+
+	// mu guards reads and writes of a schemaChange's ackedBy, which can be
+	// touched concurrently by several handlers' worker pools for the same
+	// change within one poll tick.
+	mu sync.Mutex
+
+	// pools holds one worker pool per registered handler. It is populated in
+	// Start.
+	pools map[HandlerID]*handlerWorkerPool
+
+	// telemetry holds the OpenTelemetry instruments used by the notifier. It
+	// is never nil; see defaultTelemetry.
+	telemetry *telemetry
+
+	// backoffs holds one backoffController per partition of each registered
+	// handler's worker pool, indexed the same way as that pool's queues. It
+	// is populated in Start. Scoping backoff per partition, instead of per
+	// handler, keeps one partition's repeated failures from throttling
+	// dispatch to the other, unrelated partitions the worker pool exists to
+	// keep independent.
+	backoffs map[HandlerID][]*backoffController
+	// deadLetters tracks consecutive per-(schemaChange, handler) failures in
+	// memory so a stuck pair can be promoted to the dead-letter store.
+	deadLetters *deadLetterTracker
+
+	// deadLetterMaxAttempts and deadLetterMaxElapsed bound how long a
+	// (schemaChange, handler) pair may keep failing before
+	// recordFailureAndMaybeDeadLetter moves it to the dead-letter store. They
+	// default to maxDeadLetterAttempts and maxDeadLetterElapsed but can be
+	// overridden with WithDeadLetterPolicy.
+	deadLetterMaxAttempts int
+	deadLetterMaxElapsed  time.Duration
 }
 
 // TODO(lance6716): remove this global variable. Move it into Domain and make
 // related functions a member of it.
 var globalDDLNotifier *ddlNotifier
 
+// Option configures optional behavior of the ddlNotifier. Options are applied
+// in InitDDLNotifier.
+type Option func(*ddlNotifier)
+
+// WithHandlerConcurrency sets the default number of workers started for each
+// registered handler. It defaults to 1, which reproduces the old behavior of
+// handling a handler's events one at a time.
+func WithHandlerConcurrency(concurrency int) Option {
+	return func(n *ddlNotifier) {
+		n.handlerConcurrency = concurrency
+	}
+}
+
+// WithHandlerConcurrencyOverride sets the number of workers started for a
+// specific handler, overriding the default set by WithHandlerConcurrency.
+func WithHandlerConcurrencyOverride(id HandlerID, concurrency int) Option {
+	return func(n *ddlNotifier) {
+		n.handlerConcurrencyOverrides[id] = concurrency
+	}
+}
+
+// WithDeadLetterPolicy overrides the default maxDeadLetterAttempts and
+// maxDeadLetterElapsed thresholds a (schemaChange, handler) pair tolerates
+// before it is moved to the dead-letter store.
+func WithDeadLetterPolicy(maxAttempts int, maxElapsed time.Duration) Option {
+	return func(n *ddlNotifier) {
+		n.deadLetterMaxAttempts = maxAttempts
+		n.deadLetterMaxElapsed = maxElapsed
+	}
+}
+
 // InitDDLNotifier initializes the global ddlNotifier. It should be called only
 // once and before any RegisterHandler call. The ownership of the sctx is passed
 // to the ddlNotifier.
@@ -113,13 +187,31 @@ func InitDDLNotifier(
 	sctx sessionctx.Context,
 	store Store,
 	pollInterval time.Duration,
+	opts ...Option,
 ) {
-	globalDDLNotifier = &ddlNotifier{
-		ownedSCtx:    sctx,
-		store:        store,
-		handlers:     make(map[HandlerID]SchemaChangeHandler),
-		pollInterval: pollInterval,
+	n := &ddlNotifier{
+		ownedSCtx:                   sctx,
+		store:                       store,
+		handlers:                    make(map[HandlerID]SchemaChangeHandler),
+		pollInterval:                pollInterval,
+		handlerConcurrency:          defaultHandlerConcurrency,
+		handlerConcurrencyOverrides: make(map[HandlerID]int),
+		telemetry:                   defaultTelemetry(),
+		deadLetters:                 newDeadLetterTracker(),
+		deadLetterMaxAttempts:       maxDeadLetterAttempts,
+		deadLetterMaxElapsed:        maxDeadLetterElapsed,
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	globalDDLNotifier = n
+}
+
+func (n *ddlNotifier) concurrencyFor(id HandlerID) int {
+	if c, ok := n.handlerConcurrencyOverrides[id]; ok {
+		return c
+	}
+	return n.handlerConcurrency
 }
 
 // ResetDDLNotifier is used for testing only.
@@ -133,12 +225,29 @@ func StartDDLNotifier(ctx context.Context) {
 
 // Start starts the ddlNotifier. It will block until the context is canceled.
 func (n *ddlNotifier) Start(ctx context.Context) {
-	for id := range n.handlers {
-		n.handlersBitMap |= 1 << id
-	}
-
 	ctx = kv.WithInternalSourceType(ctx, kv.InternalDDLNotifier)
 	ctx = logutil.WithCategory(ctx, "ddl-notifier")
+
+	n.pools = make(map[HandlerID]*handlerWorkerPool, len(n.handlers))
+	n.backoffs = make(map[HandlerID][]*backoffController, len(n.handlers))
+	for id, handler := range n.handlers {
+		concurrency := n.concurrencyFor(id)
+		backoffs := make([]*backoffController, concurrency)
+		for partition := range backoffs {
+			partitionID := partitionBackoffID(id, partition)
+			backoffs[partition] = newBackoffController(n, partitionID, n.loadBackoffState(ctx, partitionID))
+		}
+		n.backoffs[id] = backoffs
+		pool := newHandlerWorkerPool(n, id, handler, concurrency, backoffs)
+		pool.start(ctx)
+		n.pools[id] = pool
+	}
+	defer func() {
+		for _, pool := range n.pools {
+			pool.stop()
+		}
+	}()
+
 	ticker := time.NewTicker(n.pollInterval)
 	defer ticker.Stop()
 	for {
@@ -153,50 +262,116 @@ func (n *ddlNotifier) Start(ctx context.Context) {
 	}
 }
 
+// processEvents lists the pending schemaChanges and enqueues them onto every
+// handler's worker pool, then returns without waiting for them to be handled.
+// Dispatch is fire-and-forget by design: blocking the poll loop on one
+// handler's pool would let a single slow or stuck handler hold up delivery to
+// every other handler, which is exactly the head-of-line blocking per-handler
+// pools exist to avoid. The actual handling, ack bookkeeping, and deletion
+// happen asynchronously in the workers via processEventForHandler and
+// tryDeleteChange (see workerpool.go's process).
 func (n *ddlNotifier) processEvents(ctx context.Context) error {
 	changes, err := n.store.List(ctx, sess.NewSession(n.ownedSCtx))
 	if err != nil {
 		return errors.Trace(err)
 	}
+	n.telemetry.setBacklogSize(len(changes))
+
+	for _, pool := range n.pools {
+		pool.resetSkip()
+	}
 
-	// we should ensure deliver order of events to a handler, so if a handler returns
-	// error for previous events it should not receive later events.
-	skipHandlers := make(map[HandlerID]struct{})
 	for _, change := range changes {
-		for handlerID, handler := range n.handlers {
-			if _, ok := skipHandlers[handlerID]; ok {
-				continue
-			}
-			if err2 := n.processEventForHandler(ctx, change, handlerID, handler); err2 != nil {
-				skipHandlers[handlerID] = struct{}{}
-
-				if !goerr.Is(err2, ErrNotReadyRetryLater) {
-					logutil.Logger(ctx).Error("Error processing change",
-						zap.Int64("ddlJobID", change.ddlJobID),
-						zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
-						zap.Stringer("handler", handlerID),
-						zap.Error(err2))
-				}
+		for handlerID, pool := range n.pools {
+			if n.isProcessed(change, handlerID) {
 				continue
 			}
+			// If the queue is full we simply leave the change for the next
+			// poll tick instead of growing memory; it is still present in
+			// the Store so nothing is lost.
+			pool.dispatch(change)
 		}
+	}
 
-		if change.processedByFlag == n.handlersBitMap {
-			if err2 := n.store.DeleteAndCommit(
-				ctx,
-				sess.NewSession(n.ownedSCtx),
-				change.ddlJobID,
-				int(change.multiSchemaChangeSeq),
-			); err2 != nil {
-				logutil.Logger(ctx).Error("Error deleting change",
-					zap.Int64("ddlJobID", change.ddlJobID),
-					zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
-					zap.Error(err2))
-			}
+	return nil
+}
+
+// isProcessed reports whether change is resolved for handlerID: either
+// acknowledged, through a row in the ack table or, for a HandlerID that
+// predates it, the legacy BIGINT bitmap, or dead-lettered after repeated
+// failures. A dead-lettered pair counts as resolved so that a handler stuck
+// on one change does not keep tryDeleteChange from ever firing for it.
+func (n *ddlNotifier) isProcessed(change *schemaChange, handlerID HandlerID) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.isProcessedLocked(change, handlerID)
+}
+
+func (n *ddlNotifier) isProcessedLocked(change *schemaChange, handlerID HandlerID) bool {
+	if _, ok := change.ackedBy[handlerID]; ok {
+		return true
+	}
+	if _, ok := change.deadLettered[handlerID]; ok {
+		return true
+	}
+	if bit, ok := legacyHandlerBits[handlerID]; ok {
+		return change.processedByFlag&(1<<bit) != 0
+	}
+	return false
+}
+
+// isFullyProcessed reports whether change is resolved, as defined by
+// isProcessed, for every currently registered handler.
+func (n *ddlNotifier) isFullyProcessed(change *schemaChange) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for handlerID := range n.handlers {
+		if !n.isProcessedLocked(change, handlerID) {
+			return false
 		}
 	}
+	return true
+}
 
-	return nil
+// tryDeleteChange deletes change once every registered handler has
+// acknowledged or dead-lettered it.
+func (n *ddlNotifier) tryDeleteChange(ctx context.Context, change *schemaChange) {
+	if !n.isFullyProcessed(change) {
+		return
+	}
+
+	if err := n.store.DeleteAndCommit(
+		ctx,
+		sess.NewSession(n.ownedSCtx),
+		change.ddlJobID,
+		int(change.multiSchemaChangeSeq),
+	); err != nil {
+		logutil.Logger(ctx).Error("Error deleting change",
+			zap.Int64("ddlJobID", change.ddlJobID),
+			zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
+			zap.Error(err))
+		return
+	}
+	n.telemetry.incDeleted(ctx)
+}
+
+// RunningTasks returns the number of events currently being executed across
+// every handler's worker pool. It is intended for tests.
+func (n *ddlNotifier) RunningTasks() int32 {
+	var total int32
+	for _, pool := range n.pools {
+		total += pool.RunningTasks()
+	}
+	return total
+}
+
+// WaitAll blocks until every handler's worker pool has drained its queues. It
+// is intended for tests that need to observe the effect of a poll tick before
+// asserting on it.
+func (n *ddlNotifier) WaitAll() {
+	for _, pool := range n.pools {
+		pool.WaitAll()
+	}
 }
 
 const slowHandlerLogThreshold = time.Second * 5
@@ -207,10 +382,13 @@ func (n *ddlNotifier) processEventForHandler(
 	handlerID HandlerID,
 	handler SchemaChangeHandler,
 ) (err error) {
-	if (change.processedByFlag & (1 << handlerID)) != 0 {
+	if n.isProcessed(change, handlerID) {
 		return nil
 	}
 
+	ctx, span := n.telemetry.startSpan(ctx, change, handlerID)
+	defer span.End()
+
 	se := sess.NewSession(n.ownedSCtx)
 
 	if err = se.Begin(ctx); err != nil {
@@ -225,29 +403,39 @@ func (n *ddlNotifier) processEventForHandler(
 	}()
 
 	now := time.Now()
-	if err = handler(ctx, n.ownedSCtx, change.event); err != nil {
+	err = handler(ctx, n.ownedSCtx, change.event)
+	duration := time.Since(now)
+	n.telemetry.recordHandlerDuration(ctx, handlerID, duration)
+	if err != nil {
+		if goerr.Is(err, ErrNotReadyRetryLater) {
+			n.telemetry.incRetryLater(ctx, handlerID)
+		} else {
+			n.telemetry.incFailed(ctx, handlerID)
+			recordSpanError(span, err)
+		}
 		return errors.Trace(err)
 	}
-	if time.Since(now) > slowHandlerLogThreshold {
-		logutil.Logger(ctx).Warn("Slow process event",
-			zap.Stringer("handler", handlerID),
-			zap.Int64("ddlJobID", change.ddlJobID),
-			zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
-			zap.Stringer("event", change.event),
-			zap.Duration("duration", time.Since(now)))
+	if duration > slowHandlerLogThreshold {
+		span.AddEvent("slow handler", trace.WithAttributes(
+			attribute.Float64("duration_seconds", duration.Seconds())))
 	}
 
-	newFlag := change.processedByFlag | (1 << handlerID)
-	if err = n.store.UpdateProcessed(
+	if err = n.store.Ack(
 		ctx,
 		se,
 		change.ddlJobID,
 		change.multiSchemaChangeSeq,
-		newFlag,
+		handlerID,
 	); err != nil {
 		return errors.Trace(err)
 	}
-	change.processedByFlag = newFlag
+	n.mu.Lock()
+	if change.ackedBy == nil {
+		change.ackedBy = make(map[HandlerID]struct{})
+	}
+	change.ackedBy[handlerID] = struct{}{}
+	n.mu.Unlock()
+	n.telemetry.incDelivered(ctx, handlerID)
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDeadLetterPolicyOverridesDefaults(t *testing.T) {
+	n := &ddlNotifier{}
+	WithDeadLetterPolicy(3, time.Hour)(n)
+
+	require.Equal(t, 3, n.deadLetterMaxAttempts)
+	require.Equal(t, time.Hour, n.deadLetterMaxElapsed)
+}
+
+func TestIsProcessedLockedAckedBy(t *testing.T) {
+	n := &ddlNotifier{}
+	change := &schemaChange{ackedBy: map[HandlerID]struct{}{"h1": {}}}
+
+	require.True(t, n.isProcessedLocked(change, "h1"))
+	require.False(t, n.isProcessedLocked(change, "h2"))
+}
+
+func TestIsProcessedLockedLegacyBitmap(t *testing.T) {
+	n := &ddlNotifier{}
+	// TestHandlerID is bit 0 in legacyHandlerBits; a row written before the
+	// upgrade to the ack table only ever sets this bitmap.
+	change := &schemaChange{processedByFlag: 1 << 0}
+
+	require.True(t, n.isProcessedLocked(change, TestHandlerID))
+	// A HandlerID absent from legacyHandlerBits must never fall back to the
+	// bitmap, even if some other handler's bit happens to be set.
+	require.False(t, n.isProcessedLocked(change, "h-not-legacy"))
+}
+
+func TestIsProcessedLockedDeadLettered(t *testing.T) {
+	n := &ddlNotifier{}
+	change := &schemaChange{deadLettered: map[HandlerID]struct{}{"h1": {}}}
+
+	// A dead-lettered handler counts as resolved so it does not keep
+	// isFullyProcessed from ever becoming true for this change.
+	require.True(t, n.isProcessedLocked(change, "h1"))
+	require.False(t, n.isProcessedLocked(change, "h2"))
+}
+
+func TestIsFullyProcessedMixesAckAndDeadLetter(t *testing.T) {
+	n := &ddlNotifier{
+		handlers: map[HandlerID]SchemaChangeHandler{
+			"h1": nil,
+			"h2": nil,
+		},
+	}
+	change := &schemaChange{
+		ackedBy:      map[HandlerID]struct{}{"h1": {}},
+		deadLettered: map[HandlerID]struct{}{"h2": {}},
+	}
+
+	require.True(t, n.isFullyProcessed(change))
+}
+
+func TestIsFullyProcessedRequiresEveryHandler(t *testing.T) {
+	n := &ddlNotifier{
+		handlers: map[HandlerID]SchemaChangeHandler{
+			"h1": nil,
+			"h2": nil,
+		},
+	}
+	change := &schemaChange{ackedBy: map[HandlerID]struct{}{"h1": {}}}
+
+	require.False(t, n.isFullyProcessed(change))
+
+	change.ackedBy["h2"] = struct{}{}
+	require.True(t, n.isFullyProcessed(change))
+}
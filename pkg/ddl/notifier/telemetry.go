@@ -0,0 +1,212 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/util/logutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// instrumentationName identifies this package to OpenTelemetry.
+const instrumentationName = "github.com/pingcap/tidb/pkg/ddl/notifier"
+
+// telemetry groups the OpenTelemetry instruments used by the ddlNotifier. A
+// *ddlNotifier always has a non-nil telemetry: by default it is backed by the
+// global no-op providers, so every instrument call is cheap until an exporter
+// is configured with WithOTLPExporter.
+type telemetry struct {
+	tracer trace.Tracer
+
+	eventsDelivered  metric.Int64Counter
+	eventsFailed     metric.Int64Counter
+	eventsRetryLater metric.Int64Counter
+	eventsDeleted    metric.Int64Counter
+	handlerDuration  metric.Float64Histogram
+
+	// backlogSize is read by the backlogSize gauge's callback. It is updated
+	// once per poll tick in processEvents.
+	backlogSize atomic.Int64
+
+	// shutdown releases the exporters created by WithOTLPExporter, if any.
+	shutdown func(context.Context) error
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*telemetry, error) {
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	meter := mp.Meter(instrumentationName)
+
+	var err error
+	if t.eventsDelivered, err = meter.Int64Counter(
+		"ddl_notifier.events_delivered_total",
+		metric.WithDescription("Number of schema change events successfully delivered to a handler"),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if t.eventsFailed, err = meter.Int64Counter(
+		"ddl_notifier.events_failed_total",
+		metric.WithDescription("Number of times a handler failed to process a schema change event with an error other than ErrNotReadyRetryLater"),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if t.eventsRetryLater, err = meter.Int64Counter(
+		"ddl_notifier.events_retry_later_total",
+		metric.WithDescription("Number of times a handler asked to retry a schema change event later"),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if t.eventsDeleted, err = meter.Int64Counter(
+		"ddl_notifier.events_deleted_total",
+		metric.WithDescription("Number of schema change rows deleted after every handler processed them"),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if t.handlerDuration, err = meter.Float64Histogram(
+		"ddl_notifier.handler_duration_seconds",
+		metric.WithDescription("Duration of a single SchemaChangeHandler invocation"),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err = meter.Int64ObservableGauge(
+		"ddl_notifier.backlog_size",
+		metric.WithDescription("Number of schema change rows currently in the Store"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(t.backlogSize.Load())
+			return nil
+		}),
+	); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return t, nil
+}
+
+// defaultTelemetry returns a telemetry backed by the global, no-op
+// TracerProvider/MeterProvider so instrumentation is free when no exporter is
+// configured.
+func defaultTelemetry() *telemetry {
+	t, err := newTelemetry(otel.GetTracerProvider(), otel.GetMeterProvider())
+	if err != nil {
+		// The global no-op providers are not expected to fail to create
+		// instruments; fall back to a tracer-only telemetry so a later
+		// WithOTLPExporter call still has something sane to replace.
+		logutil.BgLogger().Error("Failed to set up ddl notifier telemetry", zap.Error(err))
+		return &telemetry{tracer: otel.GetTracerProvider().Tracer(instrumentationName)}
+	}
+	return t
+}
+
+// newOTLPTelemetry builds a telemetry backed by otlptracehttp/otlpmetrichttp
+// exporters pointed at endpoint, e.g. "localhost:4318".
+func newOTLPTelemetry(ctx context.Context, endpoint string) (*telemetry, error) {
+	traceExp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExp))
+
+	metricExp, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExp)))
+
+	t, err := newTelemetry(tp, mp)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	t.shutdown = func(ctx context.Context) error {
+		if err := tp.Shutdown(ctx); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(mp.Shutdown(ctx))
+	}
+	return t, nil
+}
+
+// WithOTLPExporter configures the ddlNotifier to export the spans and metrics
+// described on telemetry over OTLP/HTTP to endpoint (e.g. "localhost:4318").
+// If the exporters fail to set up, the notifier falls back to the default,
+// no-op telemetry and logs the error instead of failing InitDDLNotifier.
+func WithOTLPExporter(endpoint string) Option {
+	return func(n *ddlNotifier) {
+		t, err := newOTLPTelemetry(context.Background(), endpoint)
+		if err != nil {
+			logutil.BgLogger().Error("Failed to set up ddl notifier OTLP exporter",
+				zap.String("endpoint", endpoint), zap.Error(err))
+			return
+		}
+		n.telemetry = t
+	}
+}
+
+func (t *telemetry) startSpan(
+	ctx context.Context,
+	change *schemaChange,
+	handlerID HandlerID,
+) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "ddl.notifier.handle", trace.WithAttributes(
+		attribute.String("handler.id", handlerID.String()),
+		attribute.String("handler.name", handlerID.String()),
+		attribute.Int64("ddl_job_id", change.ddlJobID),
+		attribute.Int64("multi_schema_change_seq", change.multiSchemaChangeSeq),
+		attribute.String("event.type", change.event.String()),
+	))
+}
+
+func (t *telemetry) recordHandlerDuration(ctx context.Context, handlerID HandlerID, d time.Duration) {
+	t.handlerDuration.Record(ctx, d.Seconds(), metric.WithAttributes(
+		attribute.String("handler.id", handlerID.String())))
+}
+
+func (t *telemetry) incDelivered(ctx context.Context, handlerID HandlerID) {
+	t.eventsDelivered.Add(ctx, 1, metric.WithAttributes(attribute.String("handler.id", handlerID.String())))
+}
+
+func (t *telemetry) incFailed(ctx context.Context, handlerID HandlerID) {
+	t.eventsFailed.Add(ctx, 1, metric.WithAttributes(attribute.String("handler.id", handlerID.String())))
+}
+
+func (t *telemetry) incRetryLater(ctx context.Context, handlerID HandlerID) {
+	t.eventsRetryLater.Add(ctx, 1, metric.WithAttributes(attribute.String("handler.id", handlerID.String())))
+}
+
+func (t *telemetry) incDeleted(ctx context.Context) {
+	t.eventsDeleted.Add(ctx, 1)
+}
+
+func (t *telemetry) setBacklogSize(n int) {
+	t.backlogSize.Store(int64(n))
+}
+
+// recordSpanError marks span as failed with err.
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,264 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	goerr "errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/util/logutil"
+	"go.uber.org/zap"
+)
+
+// defaultHandlerConcurrency is the number of workers started for a handler
+// when the caller of InitDDLNotifier does not override it. A value of 1
+// reproduces the old behavior of handling events for a handler one at a time.
+const defaultHandlerConcurrency = 1
+
+// defaultWorkerQueueSize bounds the number of pending schemaChange tasks a
+// single worker will buffer. Once full, dispatch backs off to the next poll
+// tick instead of growing the queue.
+const defaultWorkerQueueSize = 128
+
+// schemaChangeKey identifies one schemaChange row independently of the
+// *schemaChange object a particular Store.List call happened to build for
+// it, since a still-unacked row is rebuilt as a new object every poll tick.
+type schemaChangeKey struct {
+	ddlJobID             int64
+	multiSchemaChangeSeq int64
+}
+
+func keyOf(change *schemaChange) schemaChangeKey {
+	return schemaChangeKey{ddlJobID: change.ddlJobID, multiSchemaChangeSeq: change.multiSchemaChangeSeq}
+}
+
+// handlerWorkerPool runs one or more workers for a single registered handler.
+// Tasks are hash-partitioned by ddlJobID and multiSchemaChangeSeq so that
+// every worker only ever sees events for the same set of objects, which
+// preserves per-object delivery order without serializing unrelated objects
+// behind each other.
+type handlerWorkerPool struct {
+	id       HandlerID
+	handler  SchemaChangeHandler
+	notifier *ddlNotifier
+	// backoffs holds one backoffController per partition, so one partition's
+	// repeated failures only throttle dispatch to that partition instead of
+	// every object handled by this handler.
+	backoffs []*backoffController
+
+	queues []chan *schemaChange
+	// skip holds, per partition, whether a handler error already stopped
+	// delivery to that partition for the current poll tick. It is reset at
+	// the start of every processEvents call.
+	skip []atomic.Bool
+	// running is the number of tasks currently being executed by this pool's
+	// workers. It is exported through RunningTasks for tests.
+	running atomic.Int32
+	// pending is the number of tasks that have been successfully enqueued but
+	// not yet finished processing. Unlike running, it is incremented by
+	// dispatch before the channel send and decremented by runWorker only after
+	// process returns, so there is no window where a task is neither in a
+	// queue nor counted here. WaitAll relies on this to avoid
+	// the race a running-only check would have around the dequeue in
+	// runWorker.
+	pending atomic.Int32
+
+	// inFlightMu guards inFlight.
+	inFlightMu sync.Mutex
+	// inFlight holds the key of every change currently queued or being
+	// processed by this pool. dispatch consults it, independently of the
+	// Store's own ackedBy bookkeeping, so a handler whose processing outlasts
+	// one poll tick is not handed the same still-unacked row a second time by
+	// the next tick's Store.List, which would violate SchemaChangeHandler's
+	// exactly-once contract.
+	inFlight map[schemaChangeKey]struct{}
+
+	wg sync.WaitGroup
+}
+
+func newHandlerWorkerPool(
+	n *ddlNotifier,
+	id HandlerID,
+	handler SchemaChangeHandler,
+	concurrency int,
+	backoffs []*backoffController,
+) *handlerWorkerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p := &handlerWorkerPool{
+		id:       id,
+		handler:  handler,
+		notifier: n,
+		backoffs: backoffs,
+		queues:   make([]chan *schemaChange, concurrency),
+		skip:     make([]atomic.Bool, concurrency),
+		inFlight: make(map[schemaChangeKey]struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan *schemaChange, defaultWorkerQueueSize)
+	}
+	return p
+}
+
+func (p *handlerWorkerPool) partitionOf(change *schemaChange) int {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d-%d", change.ddlJobID, change.multiSchemaChangeSeq)
+	return int(h.Sum64() % uint64(len(p.queues)))
+}
+
+// start launches one goroutine per partition. The goroutines exit once ctx is
+// canceled or their queue is closed by stop.
+func (p *handlerWorkerPool) start(ctx context.Context) {
+	for i := range p.queues {
+		p.wg.Add(1)
+		go p.runWorker(ctx, i)
+	}
+}
+
+func (p *handlerWorkerPool) runWorker(ctx context.Context, partition int) {
+	defer p.wg.Done()
+	queue := p.queues[partition]
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-queue:
+			if !ok {
+				return
+			}
+			p.running.Add(1)
+			p.process(ctx, partition, change)
+			p.clearInFlight(change)
+			p.running.Add(-1)
+			p.pending.Add(-1)
+		}
+	}
+}
+
+func (p *handlerWorkerPool) process(ctx context.Context, partition int, change *schemaChange) {
+	if p.skip[partition].Load() {
+		return
+	}
+	err := p.notifier.processEventForHandler(ctx, change, p.id, p.handler)
+	if err != nil {
+		p.skip[partition].Store(true)
+		p.backoffs[partition].onFailure(ctx, time.Now())
+		p.notifier.recordFailureAndMaybeDeadLetter(ctx, change, p.id, err)
+		if !goerr.Is(err, ErrNotReadyRetryLater) {
+			logutil.Logger(ctx).Error("Error processing change",
+				zap.Int64("ddlJobID", change.ddlJobID),
+				zap.Int64("multiSchemaChangeSeq", change.multiSchemaChangeSeq),
+				zap.Stringer("handler", p.id),
+				zap.Error(err))
+		}
+		return
+	}
+	p.backoffs[partition].onSuccess(ctx)
+	p.notifier.clearDeadLetterTracking(change, p.id)
+	p.notifier.tryDeleteChange(ctx, change)
+}
+
+// clearInFlight removes change's key from the in-flight set once its
+// processing (successful or not) has finished, so a later poll tick is free
+// to dispatch it again if it is still unacked.
+func (p *handlerWorkerPool) clearInFlight(change *schemaChange) {
+	p.inFlightMu.Lock()
+	delete(p.inFlight, keyOf(change))
+	p.inFlightMu.Unlock()
+}
+
+// dispatch enqueues change onto its partition's queue. It reports false,
+// without blocking, when that queue is already full so the caller can leave
+// the change for the next poll tick instead of growing memory. It is also a
+// no-op, reported as true, while that partition's backoffController says it
+// is not yet eligible to be retried, so a handler stuck in
+// ErrNotReadyRetryLater does not hammer the Store every tick. It also skips a
+// change whose key is already in flight in this pool, since Store.List
+// rebuilds a new *schemaChange object for a still-unacked row on every poll
+// tick and would otherwise hand the same row to the handler a second time
+// while the first delivery is still being processed.
+func (p *handlerWorkerPool) dispatch(change *schemaChange) bool {
+	partition := p.partitionOf(change)
+	if !p.backoffs[partition].ready(time.Now()) {
+		return true
+	}
+	if p.skip[partition].Load() {
+		// This partition already failed once this tick; do not deliver the
+		// rest of its events out of order.
+		return true
+	}
+
+	key := keyOf(change)
+	p.inFlightMu.Lock()
+	if _, ok := p.inFlight[key]; ok {
+		p.inFlightMu.Unlock()
+		return true
+	}
+	p.inFlight[key] = struct{}{}
+	p.inFlightMu.Unlock()
+
+	p.pending.Add(1)
+	select {
+	case p.queues[partition] <- change:
+		return true
+	default:
+		p.pending.Add(-1)
+		p.clearInFlight(change)
+		return false
+	}
+}
+
+// resetSkip clears per-partition skip state. It must be called once at the
+// start of every poll tick, before any dispatch.
+func (p *handlerWorkerPool) resetSkip() {
+	for i := range p.skip {
+		p.skip[i].Store(false)
+	}
+}
+
+// RunningTasks returns the number of events currently being executed by this
+// handler's workers. It is intended for tests.
+func (p *handlerWorkerPool) RunningTasks() int32 {
+	return p.running.Load()
+}
+
+// WaitAll blocks until every task dispatched to this pool so far has finished
+// processing. It is intended for tests that need to observe the effect of a
+// poll tick before asserting on it. It polls the single pending counter
+// rather than checking queue length and running count separately: those are
+// two independently-read fields with a window, between a worker receiving
+// from the queue and incrementing running, where a task is counted by
+// neither, which would let WaitAll return while a task is still about to
+// execute.
+func (p *handlerWorkerPool) WaitAll() {
+	for p.pending.Load() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stop closes every partition queue and waits for the worker goroutines to
+// return. Callers must not dispatch after calling stop.
+func (p *handlerWorkerPool) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
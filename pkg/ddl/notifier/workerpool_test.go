@@ -0,0 +1,176 @@
+// Copyright 2024 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/pkg/sessionctx"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, concurrency int, handler SchemaChangeHandler) *handlerWorkerPool {
+	n := &ddlNotifier{
+		store:                 &fakeStore{},
+		deadLetters:           newDeadLetterTracker(),
+		telemetry:             defaultTelemetry(),
+		deadLetterMaxAttempts: maxDeadLetterAttempts,
+		deadLetterMaxElapsed:  maxDeadLetterElapsed,
+	}
+	backoffs := make([]*backoffController, concurrency)
+	for i := range backoffs {
+		backoffs[i] = newBackoffController(n, partitionBackoffID("h1", i), backoffState{})
+	}
+	p := newHandlerWorkerPool(n, "h1", handler, concurrency, backoffs)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.start(ctx)
+	t.Cleanup(p.stop)
+	return p
+}
+
+func TestWorkerPoolPartitionOfIsStable(t *testing.T) {
+	p := &handlerWorkerPool{queues: make([]chan *schemaChange, 8)}
+	change := &schemaChange{ddlJobID: 42, multiSchemaChangeSeq: 7}
+
+	first := p.partitionOf(change)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, first, p.partitionOf(change))
+	}
+}
+
+func TestWorkerPoolDispatchBackpressure(t *testing.T) {
+	block := make(chan struct{})
+	p := newTestPool(t, 1, func(context.Context, sessionctx.Context, *SchemaChangeEvent) error {
+		<-block
+		return nil
+	})
+	defer close(block)
+
+	// The single worker is blocked on the first change, so the queue (size
+	// defaultWorkerQueueSize) fills up and dispatch must start reporting
+	// false instead of blocking the poll loop. The worker may already have
+	// taken the first change off the queue, so up to defaultWorkerQueueSize+1
+	// dispatches can succeed before one is refused.
+	var filled bool
+	for i := 0; i < defaultWorkerQueueSize+2; i++ {
+		change := &schemaChange{ddlJobID: int64(i), multiSchemaChangeSeq: 1}
+		if !p.dispatch(change) {
+			filled = true
+			break
+		}
+	}
+	require.True(t, filled, "dispatch should eventually refuse once the queue is full")
+}
+
+func TestWorkerPoolDispatchSkipsInFlightChange(t *testing.T) {
+	var invocations atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newTestPool(t, 1, func(context.Context, sessionctx.Context, *SchemaChangeEvent) error {
+		invocations.Add(1)
+		close(started)
+		<-release
+		return nil
+	})
+
+	// Store.List would hand back a brand-new *schemaChange object for the
+	// same still-unacked row on the next poll tick; simulate that by
+	// dispatching two distinct objects that share a key.
+	first := &schemaChange{ddlJobID: 1, multiSchemaChangeSeq: 1}
+	second := &schemaChange{ddlJobID: 1, multiSchemaChangeSeq: 1}
+	require.True(t, p.dispatch(first))
+	<-started
+	require.True(t, p.dispatch(second))
+
+	close(release)
+	p.WaitAll()
+	require.Equal(t, int32(1), invocations.Load(), "handler must not be invoked twice for the same in-flight key")
+}
+
+func TestWorkerPoolDispatchBackoffIsScopedPerPartition(t *testing.T) {
+	p := &handlerWorkerPool{
+		queues: make([]chan *schemaChange, 2),
+		skip:   make([]atomic.Bool, 2),
+	}
+	n := &ddlNotifier{store: &fakeStore{}, deadLetters: newDeadLetterTracker()}
+	p.backoffs = []*backoffController{
+		newBackoffController(n, partitionBackoffID("h1", 0), backoffState{}),
+		newBackoffController(n, partitionBackoffID("h1", 1), backoffState{}),
+	}
+	p.inFlight = make(map[schemaChangeKey]struct{})
+	for i := range p.queues {
+		p.queues[i] = make(chan *schemaChange, 1)
+	}
+
+	// Find one change per partition so we can back off one without touching
+	// the other.
+	var changes [2]*schemaChange
+	for id := int64(0); id < 1000 && (changes[0] == nil || changes[1] == nil); id++ {
+		change := &schemaChange{ddlJobID: id, multiSchemaChangeSeq: 1}
+		partition := p.partitionOf(change)
+		if changes[partition] == nil {
+			changes[partition] = change
+		}
+	}
+	require.NotNil(t, changes[0])
+	require.NotNil(t, changes[1])
+
+	backingOffPartition := p.partitionOf(changes[0])
+	p.backoffs[backingOffPartition].onFailure(context.Background(), time.Now())
+
+	require.True(t, p.dispatch(changes[0]), "a backing-off partition must not enqueue")
+	require.Len(t, p.queues[backingOffPartition], 0)
+
+	require.True(t, p.dispatch(changes[1]))
+	require.Len(t, p.queues[p.partitionOf(changes[1])], 1, "an unrelated partition must still be dispatched")
+}
+
+func TestWorkerPoolWaitAllObservesPendingAcrossDequeue(t *testing.T) {
+	var executing atomic.Bool
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newTestPool(t, 1, func(context.Context, sessionctx.Context, *SchemaChangeEvent) error {
+		executing.Store(true)
+		close(started)
+		<-release
+		executing.Store(false)
+		return nil
+	})
+
+	change := &schemaChange{ddlJobID: 1, multiSchemaChangeSeq: 1}
+	require.True(t, p.dispatch(change))
+
+	<-started
+	require.True(t, executing.Load())
+
+	done := make(chan struct{})
+	go func() {
+		p.WaitAll()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("WaitAll returned while the handler was still executing")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+}